@@ -0,0 +1,47 @@
+package main
+
+import "strings"
+
+// Configuration holds the user-facing settings that shape how yay checks
+// for and presents updates. It is populated from command-line flags before
+// upList/upgradePkgs run.
+type Configuration struct {
+	// NoConfirm skips the interactive upgrade selection prompt and acts
+	// on the default selection.
+	NoConfirm bool
+	// Devel enables checking VCS ("-git"/"-hg"/...) packages against
+	// their upstream source in addition to ordinary version checks.
+	Devel bool
+	// TimeUpdate treats an AUR package as upgradable when its last
+	// modified timestamp is newer than the installed build date, even if
+	// the version string itself didn't change.
+	TimeUpdate bool
+
+	// OutputFormat selects how upList renders its results. The zero
+	// value uses the interactive, ANSI-colored menu; "json" emits a
+	// stable JSON array instead, for scripts (see --print-format).
+	OutputFormat string
+
+	// UpdateSources lists the names of enabled UpdateSources, in the
+	// order they should run (see --update-sources and
+	// activeUpdateSources). A nil/empty slice keeps the built-in default
+	// list and order.
+	UpdateSources []string
+}
+
+var config = &Configuration{}
+
+// handleConfigFlag maps a yay-specific long flag to its Configuration
+// field. It returns false for flags it doesn't own, so the caller can fall
+// through to pacman's own argument parsing.
+func handleConfigFlag(flag, value string) bool {
+	switch flag {
+	case "print-format":
+		config.OutputFormat = value
+	case "update-sources":
+		config.UpdateSources = strings.Split(value, ",")
+	default:
+		return false
+	}
+	return true
+}
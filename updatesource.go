@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	alpm "github.com/jguer/go-alpm"
+)
+
+// UpdateSource is an update backend that upList can poll for packages to
+// upgrade. Built-in sources wrap upRepo and upAUR; a plugin (a Chaotic-AUR
+// mirror, a Flatpak bridge, a local package cache scan, a custom HTTP
+// endpoint, ...) can implement this to be picked up without upList itself
+// changing.
+type UpdateSource interface {
+	// Name identifies the source in config.UpdateSources and in the
+	// upgrade menu.
+	Name() string
+	// SearchingMessage is printed while this source is being polled.
+	SearchingMessage() string
+	// CheckUpdates returns packages to upgrade and packages to downgrade
+	// for this source.
+	CheckUpdates(local, remote []alpm.Package, remoteNames []string, dt *depTree) (upSlice, upSlice, error)
+}
+
+type repoUpdateSource struct{}
+
+func (repoUpdateSource) Name() string             { return "repo" }
+func (repoUpdateSource) SearchingMessage() string { return "Searching databases for updates..." }
+func (repoUpdateSource) CheckUpdates(local, remote []alpm.Package, remoteNames []string, dt *depTree) (upSlice, upSlice, error) {
+	return upRepo(local)
+}
+
+type aurUpdateSource struct{}
+
+func (aurUpdateSource) Name() string             { return "aur" }
+func (aurUpdateSource) SearchingMessage() string { return "Searching AUR for updates..." }
+func (aurUpdateSource) CheckUpdates(local, remote []alpm.Package, remoteNames []string, dt *depTree) (upSlice, upSlice, error) {
+	return upAUR(remote, remoteNames, dt)
+}
+
+// updateSources lists the available update backends in their default
+// order and numbering precedence (earliest entries get the lowest numbers
+// in the upgrade menu).
+var updateSources = []UpdateSource{
+	aurUpdateSource{},
+	repoUpdateSource{},
+}
+
+// activeUpdateSources resolves config.UpdateSources against the registry,
+// letting users disable or reorder sources by name. An empty config value
+// keeps the full default list and order.
+func activeUpdateSources() []UpdateSource {
+	if len(config.UpdateSources) == 0 {
+		return updateSources
+	}
+
+	byName := make(map[string]UpdateSource, len(updateSources))
+	for _, s := range updateSources {
+		byName[s.Name()] = s
+	}
+
+	active := make([]UpdateSource, 0, len(config.UpdateSources))
+	for _, name := range config.UpdateSources {
+		if s, ok := byName[name]; ok {
+			active = append(active, s)
+			continue
+		}
+		// An unrecognized name (e.g. a --update-sources typo) is worth
+		// flagging loudly: silently dropping it can leave active empty,
+		// which reads as "fully up to date" instead of a config error.
+		fmt.Fprint(os.Stderr, magenta("Warning: "))
+		fmt.Fprintf(os.Stderr, "unknown update source %q, ignoring\n", name)
+	}
+	return active
+}
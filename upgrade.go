@@ -2,23 +2,46 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode"
 
 	alpm "github.com/jguer/go-alpm"
 	pkgb "github.com/mikkeloscar/gopkgbuild"
 )
 
+// upgradeKind describes the direction of a proposed version change.
+type upgradeKind int
+
+const (
+	// upgradeKindUp means the remote version is newer than what is installed.
+	upgradeKindUp upgradeKind = iota
+	// upgradeKindDowngrade means the installed version is newer than the
+	// remote one, e.g. after a partial upgrade or a package dropped from
+	// testing. Acting on these reinstalls the older, remote version.
+	upgradeKindDowngrade
+)
+
+func (k upgradeKind) String() string {
+	if k == upgradeKindDowngrade {
+		return "downgrade"
+	}
+	return "up"
+}
+
 // upgrade type describes a system upgrade.
 type upgrade struct {
 	Name          string
 	Repository    string
 	LocalVersion  string
 	RemoteVersion string
+	Kind          upgradeKind
 }
 
 // upSlice is a slice of Upgrades
@@ -56,7 +79,16 @@ func (u upSlice) Less(i, j int) bool {
 	return false
 }
 
-func getVersionDiff(oldVersion, newversion string) (left, right string) {
+// getVersionDiff formats the old/new version pair for the upgrade menu.
+// isDevel must be true only for devel ("latest-commit"/SHA) upgrades,
+// whose "versions" are VCS commits rather than pacman version strings and
+// so can't be parsed with pkgb; everything else, including an all-numeric
+// repo/AUR version like "20230101", is always treated as a real version.
+func getVersionDiff(oldVersion, newversion string, isDevel bool) (left, right string) {
+	if isDevel {
+		return red(oldVersion), bold(green(newversion))
+	}
+
 	old, errOld := pkgb.NewCompleteVersion(oldVersion)
 	new, errNew := pkgb.NewCompleteVersion(newversion)
 
@@ -80,179 +112,381 @@ func getVersionDiff(oldVersion, newversion string) (left, right string) {
 	return
 }
 
+// upgradeJSON is the stable, machine-readable form of an upgrade emitted
+// when config.OutputFormat requests JSON instead of the human format.
+type upgradeJSON struct {
+	Name          string `json:"name"`
+	Repository    string `json:"repo"`
+	LocalVersion  string `json:"local_version"`
+	RemoteVersion string `json:"remote_version"`
+	Kind          string `json:"kind"`
+}
+
+// toJSON converts u to its machine-readable form.
+func (u upSlice) toJSON() []upgradeJSON {
+	out := make([]upgradeJSON, 0, len(u))
+	for _, i := range u {
+		out = append(out, upgradeJSON{i.Name, i.Repository, i.LocalVersion, i.RemoteVersion, i.Kind.String()})
+	}
+	return out
+}
+
 // Print prints the details of the packages to upgrade.
 func (u upSlice) Print(start int) {
 	for k, i := range u {
-		left, right := getVersionDiff(i.LocalVersion, i.RemoteVersion)
+		left, right := getVersionDiff(i.LocalVersion, i.RemoteVersion, i.Repository == "devel")
 
 		fmt.Print(magenta(fmt.Sprintf("%2d ", len(u)+start-k-1)))
-		fmt.Print(bold(colourHash(i.Repository)), "/", cyan(i.Name))
 
-		w := 70 - len(i.Repository) - len(i.Name) + len(left)
+		// downgradeLabel's visible length must come out of w below, or
+		// a downgrade row's "->" column drifts right of the upgrade
+		// rows around it.
+		downgradeLabel := ""
+		if i.Kind == upgradeKindDowngrade {
+			downgradeLabel = " (downgrade)"
+			fmt.Print(bold(colourHash(i.Repository)), "/", cyan(i.Name), yellow(downgradeLabel))
+		} else {
+			fmt.Print(bold(colourHash(i.Repository)), "/", cyan(i.Name))
+		}
+
+		w := 70 - len(i.Repository) - len(i.Name) - len(downgradeLabel) + len(left)
 		fmt.Printf(fmt.Sprintf("%%%ds", w),
 			fmt.Sprintf("%s -> %s\n", left, right))
 	}
 }
 
-// upList returns lists of packages to upgrade from each source.
-func upList(dt *depTree) (aurUp upSlice, repoUp upSlice, err error) {
+// sourceUpgrades pairs an UpdateSource's name with the upgrades it found,
+// keeping upList and upgradePkgs agnostic to how many sources are active.
+type sourceUpgrades struct {
+	Source   string
+	Upgrades upSlice
+}
+
+// upCheck is the work one UpdateSource does: produce upgrades and
+// downgrades, or an error.
+type upCheck func() (upSlice, upSlice, error)
+
+// gatherUpChecks runs every check concurrently and fans the results back
+// in: one upSlice per task (in task order) plus the downgrades of every
+// task that succeeded merged together, and every error encountered (a
+// failing task's downgrades are dropped). It has no dependency on
+// alpm or config, which keeps it unit-testable on its own.
+func gatherUpChecks(checks []upCheck) (results []upSlice, downUp upSlice, errs []error) {
+	results = make([]upSlice, len(checks))
+
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+
+	for idx, check := range checks {
+		wg.Add(1)
+		go func(idx int, check upCheck) {
+			defer wg.Done()
+
+			up, down, err := check()
+			results[idx] = up
+
+			mux.Lock()
+			defer mux.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			downUp = append(downUp, down...)
+		}(idx, check)
+	}
+
+	wg.Wait()
+	return
+}
+
+// upList polls every active UpdateSource for upgrades, plus any installed
+// packages that are newer than what's available remotely and are thus
+// candidates for a downgrade.
+func upList(dt *depTree) (results []sourceUpgrades, downUp upSlice, err error) {
 	local, remote, _, remoteNames, err := filterPackages()
 	if err != nil {
 		return
 	}
 
-	repoC := make(chan upSlice)
-	aurC := make(chan upSlice)
-	errC := make(chan error)
+	sources := activeUpdateSources()
+	checks := make([]upCheck, len(sources))
+	for idx, source := range sources {
+		// Progress banners are diagnostics, not data: send them to
+		// stderr so --print-format=json's stdout stays a clean array
+		// for scripts, regardless of what fires while sources are
+		// searched.
+		fmt.Fprintln(os.Stderr, bold(cyan("::")+" "+source.SearchingMessage()))
+		source := source
+		checks[idx] = func() (upSlice, upSlice, error) {
+			return source.CheckUpdates(local, remote, remoteNames, dt)
+		}
+	}
 
-	fmt.Println(bold(cyan("::") + " Searching databases for updates..."))
-	go func() {
-		repoUpList, err := upRepo(local)
-		errC <- err
-		repoC <- repoUpList
-	}()
+	upResults, down, errs := gatherUpChecks(checks)
+	downUp = down
 
-	fmt.Println(bold(cyan("::") + " Searching AUR for updates..."))
-	go func() {
-		aurUpList, err := upAUR(remote, remoteNames, dt)
-		errC <- err
-		aurC <- aurUpList
-	}()
+	results = make([]sourceUpgrades, len(sources))
+	for idx, source := range sources {
+		results[idx] = sourceUpgrades{source.Name(), upResults[idx]}
+	}
 
-	var i = 0
-loop:
-	for {
-		select {
-		case repoUp = <-repoC:
-			i++
-		case aurUp = <-aurC:
-			i++
-		case err := <-errC:
-			if err != nil {
-				fmt.Println(err)
-			}
-		default:
-			if i == 2 {
-				close(repoC)
-				close(aurC)
-				close(errC)
-				break loop
-			}
-		}
+	for _, e := range errs {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	if len(errs) > 0 {
+		err = errs[0]
 	}
+
 	return
 }
 
-func upDevel(remote []alpm.Package, packageC chan upgrade, done chan bool) {
+// upDevel checks each tracked VCS package against its upstream source and
+// only reports an upgrade when the remote HEAD commit actually moved,
+// instead of forcing a rebuild every time a check is due. A package whose
+// sources include one with a protocol getRemoteSHA can't query (see
+// isSupportedVCSProtocol) may still be reported as "latest-commit" once a
+// check is due, without any commit comparison.
+func upDevel(remote []alpm.Package, packageC chan upgrade) {
+	var wg sync.WaitGroup
+
 	for vcsName, e := range savedInfo {
-		if e.needsUpdate() {
-			found := false
-			var pkg alpm.Package
-			for _, r := range remote {
-				if r.Name() == vcsName {
-					found = true
-					pkg = r
-				}
+		if !e.needsUpdate() {
+			continue
+		}
+
+		found := false
+		var pkg alpm.Package
+		for _, r := range remote {
+			if r.Name() == vcsName {
+				found = true
+				pkg = r
 			}
-			if found {
-				if pkg.ShouldIgnore() {
-					fmt.Print(magenta("Warning: "))
-					fmt.Printf("%s ignoring package upgrade (%s => %s)\n", cyan(pkg.Name()), pkg.Version(), "git")
-				} else {
-					packageC <- upgrade{pkg.Name(), "devel", pkg.Version(), "latest-commit"}
+		}
+
+		if !found {
+			removeVCSPackage([]string{vcsName})
+			continue
+		}
+
+		if pkg.ShouldIgnore() {
+			fmt.Fprint(os.Stderr, magenta("Warning: "))
+			fmt.Fprintf(os.Stderr, "%s ignoring package upgrade (%s => %s)\n", cyan(pkg.Name()), pkg.Version(), "git")
+			continue
+		}
+
+		wg.Add(1)
+		go func(pkg alpm.Package, e vcsPkg) {
+			defer wg.Done()
+
+			for _, source := range e.Sources {
+				if !isSupportedVCSProtocol(source.Protocol) {
+					// We have no plumbing command to fetch a real
+					// remote commit for this protocol (svn+, bzr+,
+					// ...). Rather than silently dropping the package
+					// from devel checks, fall back to the old
+					// behavior of always flagging it once savedInfo
+					// says a check is due.
+					packageC <- upgrade{pkg.Name(), "devel", source.SHA, "latest-commit", upgradeKindUp}
+					return
 				}
-			} else {
-				removeVCSPackage([]string{vcsName})
+
+				newSHA, err := getRemoteSHA(source)
+				if err != nil {
+					fmt.Fprint(os.Stderr, magenta("Warning: "))
+					fmt.Fprintln(os.Stderr, err)
+					continue
+				}
+
+				if newSHA == source.SHA {
+					continue
+				}
+
+				oldSHA, newShort := source.SHA, newSHA
+				if len(oldSHA) > 7 {
+					oldSHA = oldSHA[:7]
+				}
+				if len(newShort) > 7 {
+					newShort = newShort[:7]
+				}
+
+				packageC <- upgrade{pkg.Name(), "devel", oldSHA, newShort, upgradeKindUp}
+				return
 			}
-		}
+		}(pkg, e)
 	}
-	done <- true
+
+	wg.Wait()
 }
 
 // upAUR gathers foreign packages and checks if they have new versions.
-// Output: Upgrade type package list.
-func upAUR(remote []alpm.Package, remoteNames []string, dt *depTree) (toUpgrade upSlice, err error) {
-	var routines int
-	var routineDone int
-
+// Output: Upgrade type package list, and a separate list of packages whose
+// installed version is newer than the AUR one (downgrade candidates).
+func upAUR(remote []alpm.Package, remoteNames []string, dt *depTree) (toUpgrade upSlice, toDowngrade upSlice, err error) {
+	var wg sync.WaitGroup
 	packageC := make(chan upgrade)
-	done := make(chan bool)
 
 	if config.Devel {
-		routines++
-		go upDevel(remote, packageC, done)
-		fmt.Println(bold(cyan("::") + " Checking development packages..."))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			upDevel(remote, packageC)
+		}()
+		fmt.Fprintln(os.Stderr, bold(cyan("::")+" Checking development packages..."))
 	}
 
-	routines++
+	wg.Add(1)
 	go func(remote []alpm.Package, remoteNames []string, dt *depTree) {
+		defer wg.Done()
+
 		for _, pkg := range remote {
 			aurPkg, ok := dt.Aur[pkg.Name()]
 			if !ok {
 				continue
 			}
 
+			verCmp := alpm.VerCmp(pkg.Version(), aurPkg.Version)
+
 			if (config.TimeUpdate && (int64(aurPkg.LastModified) > pkg.BuildDate().Unix())) ||
-				(alpm.VerCmp(pkg.Version(), aurPkg.Version) < 0) {
+				verCmp < 0 {
+				if pkg.ShouldIgnore() {
+					left, right := getVersionDiff(pkg.Version(), aurPkg.Version, false)
+					fmt.Fprint(os.Stderr, magenta("Warning: "))
+					fmt.Fprintf(os.Stderr, "%s ignoring package upgrade (%s => %s)\n", cyan(pkg.Name()), left, right)
+				} else {
+					packageC <- upgrade{aurPkg.Name, "aur", pkg.Version(), aurPkg.Version, upgradeKindUp}
+				}
+			} else if verCmp > 0 {
 				if pkg.ShouldIgnore() {
-					left, right := getVersionDiff(pkg.Version(), aurPkg.Version)
-					fmt.Print(magenta("Warning: "))
-					fmt.Printf("%s ignoring package upgrade (%s => %s)\n", cyan(pkg.Name()), left, right)
+					left, right := getVersionDiff(pkg.Version(), aurPkg.Version, false)
+					fmt.Fprint(os.Stderr, magenta("Warning: "))
+					fmt.Fprintf(os.Stderr, "%s ignoring package downgrade (%s => %s)\n", cyan(pkg.Name()), left, right)
 				} else {
-					packageC <- upgrade{aurPkg.Name, "aur", pkg.Version(), aurPkg.Version}
+					packageC <- upgrade{aurPkg.Name, "aur", pkg.Version(), aurPkg.Version, upgradeKindDowngrade}
 				}
 			}
 		}
-
-		done <- true
 	}(remote, remoteNames, dt)
 
-	if routineDone == routines {
-		err = nil
-		return
-	}
+	go func() {
+		wg.Wait()
+		close(packageC)
+	}()
 
-	for {
-		select {
-		case pkg := <-packageC:
-			for _, w := range toUpgrade {
-				if w.Name == pkg.Name {
-					continue
-				}
-			}
+	toUpgrade, toDowngrade = mergeUpgrades(packageC)
+	return toUpgrade, toDowngrade, nil
+}
+
+// mergeUpgrades fans in every upgrade sent on packageC until it's closed,
+// deduplicating by name (the devel and AUR producers in upAUR can both
+// notice the same package) and splitting the result by Kind. It has no
+// dependency on alpm or config, which keeps it unit-testable on its own.
+func mergeUpgrades(packageC <-chan upgrade) (toUpgrade upSlice, toDowngrade upSlice) {
+	seen := make(stringSet)
+	for pkg := range packageC {
+		if _, ok := seen[pkg.Name]; ok {
+			continue
+		}
+		seen.set(pkg.Name)
+
+		if pkg.Kind == upgradeKindDowngrade {
+			toDowngrade = append(toDowngrade, pkg)
+		} else {
 			toUpgrade = append(toUpgrade, pkg)
-		case <-done:
-			routineDone++
-			if routineDone == routines {
-				err = nil
-				return
-			}
 		}
 	}
+	return
 }
 
 // upRepo gathers local packages and checks if they have new versions.
-// Output: Upgrade type package list.
-func upRepo(local []alpm.Package) (upSlice, error) {
+// Output: Upgrade type package list, and a separate list of packages whose
+// installed version is newer than the repo one (downgrade candidates).
+//
+// The scan is split across a small worker pool so that systems with
+// thousands of installed packages don't pay for it serially.
+func upRepo(local []alpm.Package) (upSlice, upSlice, error) {
 	dbList, err := alpmHandle.SyncDbs()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	slice := upSlice{}
+	numWorkers := runtime.NumCPU()
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	if numWorkers > len(local) {
+		numWorkers = len(local)
+	}
 
-	for _, pkg := range local {
-		newPkg := pkg.NewVersion(dbList)
-		if newPkg != nil {
-			if pkg.ShouldIgnore() {
-				fmt.Print(magenta("Warning: "))
-				fmt.Printf("%s ignoring package upgrade (%s => %s)\n", pkg.Name(), pkg.Version(), newPkg.Version())
-			} else {
-				slice = append(slice, upgrade{pkg.Name(), newPkg.DB().Name(), pkg.Version(), newPkg.Version()})
+	jobs := make(chan alpm.Package)
+	upC := make(chan upgrade)
+	downC := make(chan upgrade)
+	var wg sync.WaitGroup
+
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pkg := range jobs {
+				newPkg := pkg.NewVersion(dbList)
+				if newPkg != nil {
+					if pkg.ShouldIgnore() {
+						// A single Fprintf call so concurrent workers can't
+						// interleave a "Warning: " prefix from one package
+						// with the message of another. Writes to stderr so
+						// --print-format=json's stdout stays a clean array.
+						fmt.Fprintf(os.Stderr, "%s%s ignoring package upgrade (%s => %s)\n", magenta("Warning: "), pkg.Name(), pkg.Version(), newPkg.Version())
+					} else {
+						upC <- upgrade{pkg.Name(), newPkg.DB().Name(), pkg.Version(), newPkg.Version(), upgradeKindUp}
+					}
+					continue
+				}
+
+				if syncPkg, err := dbList.FindSatisfier(pkg.Name()); err == nil {
+					if alpm.VerCmp(pkg.Version(), syncPkg.Version()) > 0 {
+						if pkg.ShouldIgnore() {
+							fmt.Fprintf(os.Stderr, "%s%s ignoring package downgrade (%s => %s)\n", magenta("Warning: "), pkg.Name(), pkg.Version(), syncPkg.Version())
+						} else {
+							downC <- upgrade{pkg.Name(), syncPkg.DB().Name(), pkg.Version(), syncPkg.Version(), upgradeKindDowngrade}
+						}
+					}
+				}
 			}
+		}()
+	}
+
+	go func() {
+		for _, pkg := range local {
+			jobs <- pkg
+		}
+		close(jobs)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(upC)
+		close(downC)
+	}()
+
+	slice := upSlice{}
+	downSlice := upSlice{}
+	for upC != nil || downC != nil {
+		select {
+		case u, ok := <-upC:
+			if !ok {
+				upC = nil
+				continue
+			}
+			slice = append(slice, u)
+		case d, ok := <-downC:
+			if !ok {
+				downC = nil
+				continue
+			}
+			downSlice = append(downSlice, d)
 		}
 	}
-	return slice, nil
+
+	return slice, downSlice, nil
 }
 
 //Contains returns whether e is present in s
@@ -278,24 +512,83 @@ func removeIntListFromList(src, target []int) []int {
 	return target
 }
 
-// upgradePkgs handles updating the cache and installing updates.
-func upgradePkgs(dt *depTree) (stringSet, stringSet, error) {
-	var repoNums []int
-	var aurNums []int
-	repoNames := make(stringSet)
-	aurNames := make(stringSet)
+// downgradeSegmentName is the pkgSegment key for the synthetic downgrade
+// section of the upgrade menu, which isn't tied to any UpdateSource.
+const downgradeSegmentName = "downgrade"
 
-	aurUp, repoUp, err := upList(dt)
+// pkgSegment is one numbered section of the upgrade menu: either an
+// UpdateSource's upgrades, or the downgrade candidates gathered across all
+// sources.
+type pkgSegment struct {
+	name string
+	pkgs upSlice
+}
+
+// upgradePkgs handles updating the cache and installing updates. The
+// returned map holds a selection set per UpdateSource name (see
+// activeUpdateSources), plus a "downgrade" entry for downgrade targets.
+func upgradePkgs(dt *depTree) (map[string]stringSet, error) {
+	results, downUp, err := upList(dt)
 	if err != nil {
-		return repoNames, aurNames, err
-	} else if len(aurUp)+len(repoUp) == 0 {
-		return repoNames, aurNames, err
+		return nil, err
+	}
+
+	segments := make([]pkgSegment, 0, len(results)+1)
+	total := 0
+	for _, r := range results {
+		sort.Sort(r.Upgrades)
+		segments = append(segments, pkgSegment{r.Source, r.Upgrades})
+		total += len(r.Upgrades)
+	}
+	sort.Sort(downUp)
+	segments = append(segments, pkgSegment{downgradeSegmentName, downUp})
+	total += len(downUp)
+
+	selection := make(map[string]stringSet, len(segments))
+	for _, seg := range segments {
+		selection[seg.name] = make(stringSet)
+	}
+
+	// JSON mode is for scripts consuming the update list, not the
+	// interactive selection menu below: emit one combined, stable array
+	// and stop, rather than a human header followed by one JSON array
+	// per segment. It runs ahead of the total == 0 check so "nothing to
+	// upgrade" still produces a parseable `[]` instead of no output.
+	if config.OutputFormat == "json" {
+		combined := make(upSlice, 0, total)
+		for _, seg := range segments {
+			combined = append(combined, seg.pkgs...)
+		}
+
+		b, err := json.Marshal(combined.toJSON())
+		if err != nil {
+			return selection, err
+		}
+		fmt.Println(string(b))
+		return selection, nil
+	}
+
+	if total == 0 {
+		return selection, nil
+	}
+
+	fmt.Println(bold(blue("::")), total, bold("Packages to upgrade."))
+
+	// Segment 0 gets the lowest numbers, the last segment (downgrades)
+	// the highest; printed top-to-bottom in the opposite order so the
+	// highest numbers appear first, as before.
+	starts := make([]int, len(segments))
+	start := 1
+	for i, seg := range segments {
+		starts[i] = start
+		start += len(seg.pkgs)
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		segments[i].pkgs.Print(starts[i])
 	}
 
-	sort.Sort(repoUp)
-	fmt.Println(bold(blue("::")), len(aurUp)+len(repoUp), bold("Packages to upgrade."))
-	repoUp.Print(len(aurUp) + 1)
-	aurUp.Print(1)
+	selected := make(map[string][]int, len(segments))
+	excluded := make(map[string][]int, len(segments))
 
 	if !config.NoConfirm {
 		fmt.Println(bold(green(arrow + " Packages to not upgrade (eg: 1 2 3, 1-3 or ^4)")))
@@ -305,12 +598,10 @@ func upgradePkgs(dt *depTree) (stringSet, stringSet, error) {
 		numberBuf, overflow, err := reader.ReadLine()
 		if err != nil || overflow {
 			fmt.Println(err)
-			return repoNames, aurNames, err
+			return selection, err
 		}
 
 		result := strings.Fields(string(numberBuf))
-		excludeAur := make([]int, 0)
-		excludeRepo := make([]int, 0)
 		for _, numS := range result {
 			negate := numS[0] == '^'
 			if negate {
@@ -326,62 +617,91 @@ func upgradePkgs(dt *depTree) (stringSet, stringSet, error) {
 			} else {
 				numbers = []int{num}
 			}
+
 			for _, target := range numbers {
-				if target > len(aurUp)+len(repoUp) || target <= 0 {
+				if target > total || target <= 0 {
 					continue
-				} else if target <= len(aurUp) {
-					target = len(aurUp) - target
-					if negate {
-						excludeAur = append(excludeAur, target)
-					} else {
-						aurNums = append(aurNums, target)
+				}
+
+				seen := 0
+				for _, seg := range segments {
+					if target > seen+len(seg.pkgs) {
+						seen += len(seg.pkgs)
+						continue
 					}
-				} else {
-					target = len(aurUp) + len(repoUp) - target
+
+					localTarget := seen + len(seg.pkgs) - target
 					if negate {
-						excludeRepo = append(excludeRepo, target)
+						excluded[seg.name] = append(excluded[seg.name], localTarget)
 					} else {
-						repoNums = append(repoNums, target)
+						selected[seg.name] = append(selected[seg.name], localTarget)
 					}
+					break
 				}
 			}
 		}
-		if len(repoNums) == 0 && len(aurNums) == 0 &&
-			(len(excludeRepo) > 0 || len(excludeAur) > 0) {
-			if len(repoUp) > 0 {
-				repoNums = BuildIntRange(0, len(repoUp)-1)
+
+		// The "only excludes given" -> "select everything else" fallback
+		// below is for upgrade segments, which default to selected. It
+		// must not apply to the downgrade segment, which defaults to
+		// nothing and is opt-in only (see below) — a bare Enter, or
+		// excluding an upgrade package, must never also queue up a
+		// downgrade.
+		anySelected := false
+		anyExcluded := false
+		for _, seg := range segments {
+			if seg.name == downgradeSegmentName {
+				continue
+			}
+			if len(selected[seg.name]) > 0 {
+				anySelected = true
 			}
-			if len(aurUp) > 0 {
-				aurNums = BuildIntRange(0, len(aurUp)-1)
+			if len(excluded[seg.name]) > 0 {
+				anyExcluded = true
 			}
 		}
-		aurNums = removeIntListFromList(excludeAur, aurNums)
-		repoNums = removeIntListFromList(excludeRepo, repoNums)
+		if !anySelected && anyExcluded {
+			for _, seg := range segments {
+				if seg.name != downgradeSegmentName && len(seg.pkgs) > 0 {
+					selected[seg.name] = BuildIntRange(0, len(seg.pkgs)-1)
+				}
+			}
+		}
+
+		for _, seg := range segments {
+			selected[seg.name] = removeIntListFromList(excluded[seg.name], selected[seg.name])
+		}
 	}
 
-	if len(repoUp) != 0 {
-	repoloop:
-		for i, k := range repoUp {
-			for _, j := range repoNums {
-				if j == i {
-					continue repoloop
+	for _, seg := range segments {
+		if len(seg.pkgs) == 0 {
+			continue
+		}
+
+		names := selection[seg.name]
+
+		if seg.name == downgradeSegmentName {
+			// Opt-in: a downgrade is only queued when its number was
+			// explicitly typed at the prompt, never by default.
+			for _, i := range selected[seg.name] {
+				if i >= 0 && i < len(seg.pkgs) {
+					names.set(seg.pkgs[i].Name)
 				}
 			}
-			repoNames.set(k.Name)
+			continue
 		}
-	}
 
-	if len(aurUp) != 0 {
-	aurloop:
-		for i, k := range aurUp {
-			for _, j := range aurNums {
+		nums := selected[seg.name]
+	segloop:
+		for i, k := range seg.pkgs {
+			for _, j := range nums {
 				if j == i {
-					continue aurloop
+					continue segloop
 				}
 			}
-			aurNames.set(k.Name)
+			names.set(k.Name)
 		}
 	}
 
-	return repoNames, aurNames, err
+	return selection, err
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+// TestMergeUpgradesDedups ensures that when two producers (e.g. the devel
+// and AUR checks inside upAUR) both emit an upgrade for the same package,
+// mergeUpgrades keeps only the first one it sees.
+func TestMergeUpgradesDedups(t *testing.T) {
+	packageC := make(chan upgrade)
+
+	go func() {
+		packageC <- upgrade{Name: "foo", Repository: "aur", Kind: upgradeKindUp}
+		packageC <- upgrade{Name: "foo", Repository: "devel", Kind: upgradeKindUp}
+		close(packageC)
+	}()
+
+	toUpgrade, toDowngrade := mergeUpgrades(packageC)
+
+	if len(toDowngrade) != 0 {
+		t.Fatalf("expected no downgrades, got %d", len(toDowngrade))
+	}
+	if len(toUpgrade) != 1 || toUpgrade[0].Repository != "aur" {
+		t.Fatalf("expected 1 deduplicated upgrade from aur, got %d: %v", len(toUpgrade), toUpgrade)
+	}
+}
+
+// TestMergeUpgradesSplitsByKind checks that downgrades and upgrades are
+// partitioned correctly while still deduplicating by name.
+func TestMergeUpgradesSplitsByKind(t *testing.T) {
+	packageC := make(chan upgrade)
+
+	go func() {
+		packageC <- upgrade{Name: "foo", Kind: upgradeKindUp}
+		packageC <- upgrade{Name: "foo", Kind: upgradeKindUp} // duplicate producer
+		packageC <- upgrade{Name: "bar", Kind: upgradeKindDowngrade}
+		close(packageC)
+	}()
+
+	toUpgrade, toDowngrade := mergeUpgrades(packageC)
+
+	if len(toUpgrade) != 1 || toUpgrade[0].Name != "foo" {
+		t.Fatalf("expected a single deduplicated upgrade for foo, got %v", toUpgrade)
+	}
+	if len(toDowngrade) != 1 || toDowngrade[0].Name != "bar" {
+		t.Fatalf("expected a single downgrade for bar, got %v", toDowngrade)
+	}
+}
+
+// TestGatherUpChecksPropagatesErrors ensures an error from one task doesn't
+// get dropped when another task succeeds, and that every task's error is
+// collected rather than only the opportunistically-read one.
+func TestGatherUpChecksPropagatesErrors(t *testing.T) {
+	errFoo := errors.New("foo source unreachable")
+	errBar := errors.New("bar source unreachable")
+
+	checks := []upCheck{
+		func() (upSlice, upSlice, error) {
+			return upSlice{{Name: "ok-pkg", Kind: upgradeKindUp}}, nil, nil
+		},
+		func() (upSlice, upSlice, error) {
+			return nil, nil, errFoo
+		},
+		func() (upSlice, upSlice, error) {
+			return nil, upSlice{{Name: "down-pkg", Kind: upgradeKindDowngrade}}, nil
+		},
+		func() (upSlice, upSlice, error) {
+			return nil, nil, errBar
+		},
+	}
+
+	results, downUp, errs := gatherUpChecks(checks)
+
+	if len(errs) != 2 {
+		t.Fatalf("expected both errors to propagate, got %d: %v", len(errs), errs)
+	}
+	seen := map[error]bool{}
+	for _, e := range errs {
+		seen[e] = true
+	}
+	if !seen[errFoo] || !seen[errBar] {
+		t.Fatalf("expected both errFoo and errBar in %v", errs)
+	}
+
+	if len(results) != len(checks) {
+		t.Fatalf("expected one result slot per task, got %d", len(results))
+	}
+	if len(results[0]) != 1 || results[0][0].Name != "ok-pkg" {
+		t.Fatalf("expected the successful task's upgrades to survive, got %v", results[0])
+	}
+
+	if len(downUp) != 1 || downUp[0].Name != "down-pkg" {
+		t.Fatalf("expected the erroring tasks' downgrades to be skipped and the others kept, got %v", downUp)
+	}
+}
+
+// TestGatherUpChecksNoErrors is the happy path: every task succeeds and all
+// downgrades are merged together.
+func TestGatherUpChecksNoErrors(t *testing.T) {
+	checks := []upCheck{
+		func() (upSlice, upSlice, error) {
+			return upSlice{{Name: "a", Kind: upgradeKindUp}}, upSlice{{Name: "a-old", Kind: upgradeKindDowngrade}}, nil
+		},
+		func() (upSlice, upSlice, error) {
+			return upSlice{{Name: "b", Kind: upgradeKindUp}}, nil, nil
+		},
+	}
+
+	results, downUp, errs := gatherUpChecks(checks)
+	if len(errs) != 0 {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+	if len(results) != 2 || results[0][0].Name != "a" || results[1][0].Name != "b" {
+		t.Fatalf("expected per-task results in task order, got %v", results)
+	}
+
+	names := make([]string, 0, len(downUp))
+	for _, d := range downUp {
+		names = append(names, d.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 1 || names[0] != "a-old" {
+		t.Fatalf("expected downgrades from successful tasks to be merged, got %v", names)
+	}
+}
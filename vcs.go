@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// vcsSource is one upstream location a devel package is tracked against,
+// along with the commit we last saw there.
+type vcsSource struct {
+	URL      string
+	Protocol string
+	SHA      string
+}
+
+// vcsPkg is the saved VCS tracking state for a single devel package.
+type vcsPkg struct {
+	Sources []vcsSource
+}
+
+// vcsInfo maps a devel package name to its tracked VCS sources.
+type vcsInfo map[string]vcsPkg
+
+var savedInfo = make(vcsInfo)
+
+// needsUpdate reports whether this package has tracked sources worth
+// rechecking against upstream.
+func (p vcsPkg) needsUpdate() bool {
+	return len(p.Sources) > 0
+}
+
+const (
+	// vcsCheckConcurrency caps how many remotes are queried at once so a
+	// large number of devel packages doesn't open a flood of connections.
+	vcsCheckConcurrency = 5
+	// vcsCheckTimeout bounds a single remote query so one hung source
+	// can't stall the rest of the update check.
+	vcsCheckTimeout = 5 * time.Second
+)
+
+var vcsCheckSem = make(chan struct{}, vcsCheckConcurrency)
+
+// supportedVCSProtocols are the makepkg VCS prefixes getRemoteSHA knows how
+// to query a real remote commit for.
+var supportedVCSProtocols = []string{"git", "hg"}
+
+// isSupportedVCSProtocol reports whether getRemoteSHA can compare real
+// commits for protocol. Anything else (svn+, bzr+, ...) has no plumbing
+// command wired up here.
+func isSupportedVCSProtocol(protocol string) bool {
+	for _, p := range supportedVCSProtocols {
+		if strings.HasPrefix(protocol, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// getRemoteSHA returns the current commit of a tracked VCS source, using
+// the plumbing command appropriate for its protocol.
+func getRemoteSHA(source vcsSource) (string, error) {
+	vcsCheckSem <- struct{}{}
+	defer func() { <-vcsCheckSem }()
+
+	var cmd *exec.Cmd
+	switch {
+	case strings.HasPrefix(source.Protocol, "git"):
+		cmd = exec.Command("git", "ls-remote", source.URL, "HEAD")
+	case strings.HasPrefix(source.Protocol, "hg"):
+		cmd = exec.Command("hg", "identify", source.URL)
+	default:
+		return "", fmt.Errorf("%s: unsupported VCS protocol %q", source.URL, source.Protocol)
+	}
+
+	type cmdResult struct {
+		out []byte
+		err error
+	}
+	resC := make(chan cmdResult, 1)
+	go func() {
+		out, err := cmd.Output()
+		resC <- cmdResult{out, err}
+	}()
+
+	select {
+	case res := <-resC:
+		if res.err != nil {
+			return "", res.err
+		}
+		fields := strings.Fields(string(res.out))
+		if len(fields) == 0 {
+			return "", fmt.Errorf("%s: could not parse remote output", source.URL)
+		}
+		return fields[0], nil
+	case <-time.After(vcsCheckTimeout):
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+		return "", fmt.Errorf("%s: timed out checking for updates", source.URL)
+	}
+}